@@ -1,16 +1,76 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"io"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// pingHistorySize bounds how many outstanding pings we track at once; a
+// client that never pongs back simply ages its slot out.
+const pingHistorySize = 8
+
+// rttHistorySize bounds how many RTT samples AvgRTT is computed over.
+const rttHistorySize = 32
+
+// pingRecord is one entry in a Client's outstanding-ping ring buffer.
+type pingRecord struct {
+	seq  uint64
+	sent time.Time
+}
+
 // ClientList is a map used to help manage a map of clients
 type ClientList map[*Client]bool
 
+// SlowClientPolicy decides what happens when a Client's egress buffer is full,
+// i.e. the client isn't reading its websocket fast enough to keep up.
+type SlowClientPolicy int
+
+const (
+	// Drop silently discards the new event, keeping whatever is already queued.
+	Drop SlowClientPolicy = iota
+	// DropOldest discards the oldest queued event to make room for the new one.
+	DropOldest
+	// Disconnect closes the client and unregisters it from the Manager.
+	Disconnect
+)
+
+// ClientOptions configures a Client's buffering and write behaviour. The zero
+// value is not usable directly; use NewClient which fills in the defaults
+// below.
+type ClientOptions struct {
+	// EgressBuffer is the number of Events the client's outbound channel can
+	// hold before SlowClientPolicy kicks in.
+	EgressBuffer int
+	// WriteWait bounds how long a single WriteMessage call is allowed to block.
+	WriteWait time.Duration
+	// SlowClientPolicy controls what happens once EgressBuffer fills up.
+	SlowClientPolicy SlowClientPolicy
+	// MaxMessageSize is the hard ceiling, in bytes, passed to
+	// SetReadLimit: the connection is killed if a frame exceeds it,
+	// regardless of SizePolicy.
+	MaxMessageSize int64
+	// SizePolicy is the softer, per-event-type limit enforced once a message
+	// has been decoded. Oversized events are rejected with a structured
+	// error Event instead of tearing down the connection.
+	SizePolicy SizePolicy
+}
+
+// DefaultClientOptions supplies the values NewClient uses for zero-valued
+// options fields (except SlowClientPolicy; see NewClient).
+var DefaultClientOptions = ClientOptions{
+	EgressBuffer:     16,
+	WriteWait:        10 * time.Second,
+	SlowClientPolicy: Disconnect,
+	MaxMessageSize:   512,
+}
+
 // Client is a websocket client, basically a frontend visitor
 type Client struct {
 	// the websocket connection
@@ -29,15 +89,170 @@ type Client struct {
 	// egress is used to avoid concurrent writes on the WebSocket
 	// egress chan []byte
 	egress chan Event
+
+	// options holds the buffering/backpressure settings this client was created with
+	options ClientOptions
+
+	// codec marshals/unmarshals Events on the wire. Defaults to jsonCodec and
+	// is overridden by SetCodec once the upgrade negotiates a subprotocol.
+	codec Codec
+
+	// pingMu guards pingSeq and outstanding, which writeMessages' ticker
+	// branch writes and pongHandler (invoked from readMessages) reads.
+	pingMu sync.Mutex
+	// pingSeq is the sequence id of the last ping we sent.
+	pingSeq uint64
+	// outstanding is a ring buffer of pings awaiting a pong, indexed by seq % pingHistorySize.
+	outstanding [pingHistorySize]pingRecord
+
+	rttMu      sync.Mutex
+	rttSamples [rttHistorySize]time.Duration
+	rttCount   int
+	rttIndex   int
+	lastRTT    time.Duration
+
+	closeOnce sync.Once
+	// closeSignal is closed by close() to tell writeMessages to drain egress
+	// and send a close frame. egress itself is never closed: send() (and
+	// anything else writing to it) runs on other goroutines we don't
+	// coordinate with close(), and closing a channel other goroutines still
+	// send on panics.
+	closeSignal chan struct{}
+	// closed is closed by writeMessages right before it returns, so callers
+	// like Manager.Shutdown can wait for the egress queue to actually drain
+	// instead of for close() to merely signal intent.
+	closed      chan struct{}
+	closeCode   int
+	closeReason string
 }
 
-// NewClient is used to initialize a new Client with all required values initialized
-func NewClient(conn *websocket.Conn, manager *Manager) *Client {
-	return &Client{
+// NewClient is used to initialize a new Client with all required values initialized.
+// Zero-valued EgressBuffer, WriteWait, MaxMessageSize and SizePolicy fall back
+// to their DefaultClientOptions values. SlowClientPolicy is used as given: its
+// zero value is Drop, not DefaultClientOptions' Disconnect.
+func NewClient(conn *websocket.Conn, manager *Manager, options ClientOptions) *Client {
+	if options.EgressBuffer == 0 {
+		options.EgressBuffer = DefaultClientOptions.EgressBuffer
+	}
+	if options.WriteWait == 0 {
+		options.WriteWait = DefaultClientOptions.WriteWait
+	}
+	if options.MaxMessageSize == 0 {
+		options.MaxMessageSize = DefaultClientOptions.MaxMessageSize
+	}
+	if options.SizePolicy.Default == 0 && options.SizePolicy.Limits == nil {
+		options.SizePolicy = DefaultSizePolicy
+	}
+	c := &Client{
 		connection: conn,
 		manager:    manager,
 		// egress:     make(chan []byte),
-		egress: make(chan Event),
+		egress:      make(chan Event, options.EgressBuffer),
+		options:     options,
+		codec:       jsonCodec{},
+		closeSignal: make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+	manager.metrics.recordConnect()
+	return c
+}
+
+// LastRTT returns the most recently measured ping/pong round-trip time.
+func (c *Client) LastRTT() time.Duration {
+	c.rttMu.Lock()
+	defer c.rttMu.Unlock()
+	return c.lastRTT
+}
+
+// AvgRTT returns the mean of up to the last rttHistorySize RTT samples.
+func (c *Client) AvgRTT() time.Duration {
+	c.rttMu.Lock()
+	defer c.rttMu.Unlock()
+	if c.rttCount == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for i := 0; i < c.rttCount; i++ {
+		sum += c.rttSamples[i]
+	}
+	return sum / time.Duration(c.rttCount)
+}
+
+// recordRTT stores d as the latest RTT sample, both locally and in the
+// owning Manager's metrics registry.
+func (c *Client) recordRTT(d time.Duration) {
+	c.rttMu.Lock()
+	c.lastRTT = d
+	c.rttSamples[c.rttIndex%rttHistorySize] = d
+	c.rttIndex++
+	if c.rttCount < rttHistorySize {
+		c.rttCount++
+	}
+	c.rttMu.Unlock()
+
+	c.manager.metrics.recordRTT(d)
+}
+
+// close marks the client for shutdown with the given websocket close code and
+// human-readable reason, then signals writeMessages to drain its egress
+// queue and send a proper close frame instead of an empty one. Safe to call
+// more than once or concurrently; only the first call has any effect. It
+// returns immediately — wait on Closed() to know writeMessages actually
+// finished draining and exited.
+func (c *Client) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeReason = reason
+		close(c.closeSignal)
+	})
+}
+
+// Closed returns a channel that is closed once writeMessages has returned.
+// When it returns because close() was called, that is after it has drained
+// the egress queue and sent the close frame; when it returns on an encode or
+// write error, the queue is not drained.
+func (c *Client) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// SetCodec overrides the wire codec used by readMessages/writeMessages. The
+// Manager calls this right after the upgrade once it has negotiated a
+// subprotocol via NegotiateCodec.
+func (c *Client) SetCodec(codec Codec) {
+	c.codec = codec
+}
+
+// send delivers event to this client's egress queue, applying
+// options.SlowClientPolicy when the queue is full instead of blocking the
+// caller. Under Disconnect it calls Manager.removeClient, which takes the
+// Manager's lock, so the caller must not hold that lock. It reports whether
+// the client is still usable afterwards.
+func (c *Client) send(event Event) bool {
+	select {
+	case c.egress <- event:
+		return true
+	default:
+	}
+
+	switch c.options.SlowClientPolicy {
+	case DropOldest:
+		select {
+		case <-c.egress:
+		default:
+		}
+		select {
+		case c.egress <- event:
+		default:
+			// Still full; give up on this event rather than block.
+		}
+		return true
+	case Disconnect:
+		c.manager.removeClient(c)
+		return false
+	case Drop:
+		fallthrough
+	default:
+		return true
 	}
 }
 
@@ -55,7 +270,7 @@ func (c *Client) readMessages() {
 	// This has to be done here to set the first initial timer.
 
 	// Set Max Size of Messages in Bytes
-	c.connection.SetReadLimit(512)
+	c.connection.SetReadLimit(c.options.MaxMessageSize)
 	// If you restart and try sending a long message, the connection will close.
 
 	if err := c.connection.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
@@ -67,11 +282,10 @@ func (c *Client) readMessages() {
 
 	// Loop Forever
 	for {
-		// ReadMessage is used to read the next message in queue
-		// in the connection
-		// messageType, payload, err := c.connection.ReadMessage()
-		_, payload, err := c.connection.ReadMessage()
-
+		// NextReader (rather than ReadMessage) lets us stream the frame
+		// through a bounded reader instead of forcing gorilla to allocate
+		// the whole thing up front.
+		_, reader, err := c.connection.NextReader()
 		if err != nil {
 			// If Connection is closed, we will Recieve an error here
 			// We only want to log Strange errors, but simple Disconnection
@@ -80,16 +294,34 @@ func (c *Client) readMessages() {
 			}
 			break // Break the loop to close conn & Cleanup
 		}
-		// log.Println("MessageType: ", messageType)
-		// log.Println("Payload: ", string(payload))
 
-		// Marshal incoming data into a Event struct
+		buf := bufferPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		// +1 so a message that is exactly at the ceiling isn't mistaken for
+		// one that got truncated.
+		if _, err := io.Copy(buf, io.LimitReader(reader, c.options.SizePolicy.max()+1)); err != nil {
+			log.Printf("error reading message: %v", err)
+			bufferPool.Put(buf)
+			break
+		}
+
+		c.manager.metrics.recordMessageIn(buf.Len())
+
+		// Decode incoming data into a Event struct using the negotiated codec
 		var request Event
-		if err := json.Unmarshal(payload, &request); err != nil {
+		err = c.codec.Decode(buf.Bytes(), &request)
+		bufferPool.Put(buf)
+		if err != nil {
 			log.Printf("error marshalling message: %v", err)
 			break // breaking the connection here might be harsh
 
 		}
+
+		if limit := c.options.SizePolicy.LimitFor(request.Type); int64(len(request.Payload)) > limit {
+			c.rejectOversized(request.Type, limit)
+			continue
+		}
+
 		//Route the Event
 		if err := c.manager.routeEvent(request, c); err != nil {
 			log.Println("Error handeling Message: ", err)
@@ -98,13 +330,47 @@ func (c *Client) readMessages() {
 	}
 }
 
-// pongHandler is used to handle PongMessages for the Client
+// bufferPool recycles the scratch buffers readMessages streams each frame
+// into, avoiding a fresh allocation per message.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// rejectOversized tells the sender their event was rejected for exceeding
+// limit, instead of silently dropping it or tearing down the connection.
+func (c *Client) rejectOversized(eventType string, limit int64) {
+	payload, err := json.Marshal(OversizedEventError{
+		Event: eventType,
+		Limit: limit,
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	c.send(Event{Type: EventTypeError, Payload: payload})
+}
+
+// pongHandler is used to handle PongMessages for the Client. The payload
+// echoes whatever we sent in the ping (see writeMessages), so we can match it
+// back to the outstanding ping and measure round-trip latency.
 func (c *Client) pongHandler(pongMsg string) error {
-	// Current time + Pong Wait time
-	log.Println("pong")
+	payload := []byte(pongMsg)
+	if len(payload) == 16 {
+		seq := binary.BigEndian.Uint64(payload[0:8])
+		c.pingMu.Lock()
+		rec := c.outstanding[seq%pingHistorySize]
+		c.pingMu.Unlock()
+		if rec.seq == seq {
+			rtt := time.Since(rec.sent)
+			c.recordRTT(rtt)
+			log.Printf("pong: seq=%d rtt=%s", seq, rtt)
+		}
+	} else {
+		log.Println("pong")
+	}
 
+	// Current time + Pong Wait time
 	return c.connection.SetReadDeadline(time.Now().Add(pongWait))
-
 }
 
 /*
@@ -131,47 +397,99 @@ func (c *Client) writeMessages() {
 	ticker := time.NewTicker(pingInterval)
 	defer func() {
 		ticker.Stop()
+		close(c.closed)
 		// Graceful close if this triggers a closing
 		c.manager.removeClient(c)
 	}()
 
 	for {
 		select {
-		case message, ok := <-c.egress:
-			// Ok will be false Incase the egress channel is closed
-			if !ok {
-				// Manager has closed this connection channel, so communicate that to frontend
-				if err := c.connection.WriteMessage(websocket.CloseMessage, nil); err != nil {
-					// Log that the connection is closed and the reason
-					log.Println("connection closed: ", err)
-				}
-				// Return to close the goroutine
+		case message := <-c.egress:
+			if !c.writeEvent(message) {
 				return
 			}
-			data, err := json.Marshal(message)
-			if err != nil {
-				log.Println(err)
-				return // closes the connection, should we really
-			}
-			// Write a Regular text message to the connection
-			//if err := c.connection.WriteMessage(websocket.TextMessage, message); err != nil {
-			if err := c.connection.WriteMessage(websocket.TextMessage, data); err != nil {
+
+		case <-ticker.C:
+			if err := c.connection.SetWriteDeadline(time.Now().Add(c.options.WriteWait)); err != nil {
 				log.Println(err)
+				return
 			}
-			log.Println("sent message")
 
-		case <-ticker.C:
+			// Stamp the ping with a sequence id and send time so pongHandler
+			// can correlate the reply and compute RTT.
+			c.pingMu.Lock()
+			c.pingSeq++
+			seq := c.pingSeq
+			c.outstanding[seq%pingHistorySize] = pingRecord{seq: seq, sent: time.Now()}
+			c.pingMu.Unlock()
+
+			payload := make([]byte, 16)
+			binary.BigEndian.PutUint64(payload[0:8], seq)
+			binary.BigEndian.PutUint64(payload[8:16], uint64(time.Now().UnixNano()))
+
 			log.Println("ping")
-			// Send the Ping
-			if err := c.connection.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
+			if err := c.connection.WriteMessage(websocket.PingMessage, payload); err != nil {
 				log.Println("writemsg: ", err)
 				return // return to break this goroutine triggeing cleanup
 			}
+
+		case <-c.closeSignal:
+			// Flush whatever is already queued before telling the peer
+			// we're closing, so Shutdown doesn't drop in-flight events.
+			c.drainEgress()
+
+			code := c.closeCode
+			if code == 0 {
+				code = websocket.CloseNormalClosure
+			}
+			if err := c.connection.SetWriteDeadline(time.Now().Add(c.options.WriteWait)); err != nil {
+				log.Println(err)
+				return
+			}
+			if err := c.connection.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, c.closeReason)); err != nil {
+				log.Println("connection closed: ", err)
+			}
+			return
 		}
 
 	}
 }
 
+// writeEvent encodes and writes a single queued Event. It reports whether
+// writeMessages should keep looping.
+func (c *Client) writeEvent(message Event) bool {
+	data, messageType, err := c.codec.Encode(message)
+	if err != nil {
+		log.Println(err)
+		return false // closes the connection, should we really
+	}
+	if err := c.connection.SetWriteDeadline(time.Now().Add(c.options.WriteWait)); err != nil {
+		log.Println(err)
+		return false
+	}
+	// Write the message using whichever type the negotiated codec requires
+	if err := c.connection.WriteMessage(messageType, data); err != nil {
+		log.Println(err)
+	}
+	c.manager.metrics.recordMessageOut(len(data))
+	log.Println("sent message")
+	return true
+}
+
+// drainEgress writes out every Event already queued in egress without
+// blocking for more. Called right before the close frame so a
+// Shutdown-triggered close doesn't drop events that were already in flight.
+func (c *Client) drainEgress() {
+	for {
+		select {
+		case message := <-c.egress:
+			c.writeEvent(message)
+		default:
+			return
+		}
+	}
+}
+
 var (
 	// pongWait is how long we will await a pong response from client
 	pongWait = 10 * time.Second
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestClientPingRecordConcurrentAccess exercises the same interleaving that
+// used to race: one goroutine recording an outstanding ping (as
+// writeMessages' ticker branch does) while another reads it back (as
+// pongHandler does), both touching the same ring buffer slot.
+func TestClientPingRecordConcurrentAccess(t *testing.T) {
+	c := &Client{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			c.pingMu.Lock()
+			c.pingSeq++
+			seq := c.pingSeq
+			c.outstanding[seq%pingHistorySize] = pingRecord{seq: seq, sent: time.Now()}
+			c.pingMu.Unlock()
+		}()
+		go func() {
+			defer wg.Done()
+			c.pingMu.Lock()
+			_ = c.outstanding[c.pingSeq%pingHistorySize]
+			c.pingMu.Unlock()
+		}()
+	}
+	wg.Wait()
+}
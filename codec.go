@@ -0,0 +1,37 @@
+package main
+
+// Codec converts between wire bytes and Event values. Implementations are
+// selected per-connection via the Sec-WebSocket-Protocol negotiated during
+// the HTTP upgrade, so a single server can serve JSON, MessagePack and
+// Protobuf peers side by side.
+type Codec interface {
+	// Name is the Sec-WebSocket-Protocol token this codec answers to,
+	// e.g. "chat.json.v1".
+	Name() string
+	// Decode unmarshals payload into event.
+	Decode(payload []byte, event *Event) error
+	// Encode marshals event into wire bytes and reports the websocket
+	// message type (TextMessage or BinaryMessage) it should be sent as.
+	Encode(event Event) ([]byte, int, error)
+}
+
+// SupportedCodecs lists every Codec the server can negotiate, keyed by the
+// subprotocol name advertised in Sec-WebSocket-Protocol.
+var SupportedCodecs = map[string]Codec{
+	jsonCodec{}.Name():     jsonCodec{},
+	msgpackCodec{}.Name():  msgpackCodec{},
+	protobufCodec{}.Name(): protobufCodec{},
+}
+
+// NegotiateCodec picks a Codec from the subprotocols the peer offered during
+// the upgrade (gorilla/websocket populates this from Sec-WebSocket-Protocol
+// via Upgrader.Subprotocols/r.Header). It falls back to JSON when the peer
+// didn't ask for anything we recognise, so existing clients keep working.
+func NegotiateCodec(protocols []string) Codec {
+	for _, p := range protocols {
+		if codec, ok := SupportedCodecs[p]; ok {
+			return codec
+		}
+	}
+	return jsonCodec{}
+}
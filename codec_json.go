@@ -0,0 +1,22 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+)
+
+// jsonCodec is the default, human-readable wire format and is what every
+// client spoke before subprotocol negotiation existed.
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "chat.json.v1" }
+
+func (jsonCodec) Decode(payload []byte, event *Event) error {
+	return json.Unmarshal(payload, event)
+}
+
+func (jsonCodec) Encode(event Event) ([]byte, int, error) {
+	data, err := json.Marshal(event)
+	return data, websocket.TextMessage, err
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// msgpackCodec trades JSON's readability for a smaller wire size, useful for
+// high-frequency broadcast workloads.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "chat.msgpack.v1" }
+
+func (msgpackCodec) Decode(payload []byte, event *Event) error {
+	return msgpack.Unmarshal(payload, event)
+}
+
+func (msgpackCodec) Encode(event Event) ([]byte, int, error) {
+	data, err := msgpack.Marshal(event)
+	return data, websocket.BinaryMessage, err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protobufCodec encodes an Event as a minimal two-field protobuf message:
+//
+//	field 1 (type), string
+//	field 2 (payload), bytes
+//
+// It's hand-rolled with protowire rather than a generated .pb.go so this
+// package doesn't need a protoc step; swap in generated code if the Event
+// schema grows beyond these two fields.
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string { return "chat.proto.v1" }
+
+func (protobufCodec) Decode(payload []byte, event *Event) error {
+	buf := payload
+	for len(buf) > 0 {
+		num, typ, n := protowire.ConsumeTag(buf)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		buf = buf[n:]
+
+		switch num {
+		case 1:
+			v, n := protowire.ConsumeString(buf)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			event.Type = v
+			buf = buf[n:]
+		case 2:
+			v, n := protowire.ConsumeBytes(buf)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			event.Payload = append([]byte(nil), v...)
+			buf = buf[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, buf)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			buf = buf[n:]
+		}
+	}
+	return nil
+}
+
+func (protobufCodec) Encode(event Event) ([]byte, int, error) {
+	var buf []byte
+	buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+	buf = protowire.AppendString(buf, event.Type)
+	buf = protowire.AppendTag(buf, 2, protowire.BytesType)
+	buf = protowire.AppendBytes(buf, event.Payload)
+	return buf, websocket.BinaryMessage, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import "encoding/json"
+
+// Event is the Message sent over the websocket
+// Used to differ between different actions
+type Event struct {
+	// Type is the message type sent
+	Type string `json:"type"`
+	// Payload is the data based on the Type
+	Payload json.RawMessage `json:"payload"`
+}
+
+// EventHandler is a function signature that is used to affect messages on the socket and triggered
+// depending on the type
+type EventHandler func(event Event, c *Client) error
+
+// EventTypeError is the Event.Type used to report a rejected request back to
+// its sender, e.g. when a payload exceeds its SizePolicy limit.
+const EventTypeError = "error"
+
+// OversizedEventError is the Payload of an EventTypeError Event sent when an
+// incoming event was rejected for exceeding its SizePolicy limit.
+type OversizedEventError struct {
+	// Event is the Type of the rejected event.
+	Event string `json:"event"`
+	// Limit is the byte limit that was exceeded.
+	Limit int64 `json:"limit"`
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrEventNotSupported is returned by routeEvent when no handler is
+// registered for the incoming Event's Type.
+var ErrEventNotSupported = errors.New("this event type is not supported")
+
+// subprotocols is the list advertised to the Upgrader so a peer can opt into
+// a non-default Codec via Sec-WebSocket-Protocol.
+func subprotocols() []string {
+	protocols := make([]string, 0, len(SupportedCodecs))
+	for name := range SupportedCodecs {
+		protocols = append(protocols, name)
+	}
+	return protocols
+}
+
+var websocketUpgrader = websocket.Upgrader{
+	CheckOrigin:     func(r *http.Request) bool { return true },
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	Subprotocols:    subprotocols(),
+}
+
+// Manager is used to hold references to all Clients registered, and broadcasting etc
+type Manager struct {
+	sync.RWMutex
+	clients ClientList
+
+	// handlers maps an Event's Type to the function that processes it
+	handlers map[string]EventHandler
+
+	// accepting is false once Shutdown has started; serveWS then refuses new upgrades
+	accepting bool
+
+	// sizePolicy is handed to every Client serveWS creates. Configure it via
+	// SetMaxSize/SetDefaultMaxSize before (or while) the server is accepting
+	// connections; already-connected clients keep whatever policy they were
+	// created with.
+	sizePolicy SizePolicy
+
+	// metrics is the counter set this Manager's Clients report into. Each
+	// Manager gets its own, so running more than one (e.g. in tests) doesn't
+	// conflate their connection counts and RTT histograms.
+	metrics *metricsRegistry
+}
+
+// NewManager is used to initialize all the values inside the manager
+func NewManager() *Manager {
+	return &Manager{
+		clients:    make(ClientList),
+		handlers:   make(map[string]EventHandler),
+		accepting:  true,
+		sizePolicy: DefaultSizePolicy,
+		metrics:    newMetricsRegistry(),
+	}
+}
+
+// SetMaxSize registers the maximum payload size, in bytes, accepted for
+// eventType. It only affects clients connecting after the call; e.g.
+// manager.SetMaxSize("send_message", 8<<10).
+func (m *Manager) SetMaxSize(eventType string, limit int64) {
+	m.Lock()
+	defer m.Unlock()
+	// Copy rather than mutate in place: serveWS reads m.sizePolicy under
+	// RLock and hands the SizePolicy (map and all) to NewClient, so mutating
+	// the existing map could race with a client still reading it.
+	limits := make(map[string]int64, len(m.sizePolicy.Limits)+1)
+	for t, l := range m.sizePolicy.Limits {
+		limits[t] = l
+	}
+	limits[eventType] = limit
+	m.sizePolicy.Limits = limits
+}
+
+// SetDefaultMaxSize registers the maximum payload size, in bytes, accepted
+// for event types with no limit of their own via SetMaxSize.
+func (m *Manager) SetDefaultMaxSize(limit int64) {
+	m.Lock()
+	defer m.Unlock()
+	m.sizePolicy.Default = limit
+}
+
+// routeEvent is used to make sure the correct Event goes into the correct handler
+func (m *Manager) routeEvent(event Event, c *Client) error {
+	handler, ok := m.handlers[event.Type]
+	if !ok {
+		return ErrEventNotSupported
+	}
+	return handler(event, c)
+}
+
+// serveWS is a HTTP Handler that upgrades the connection, negotiates a Codec
+// from the offered subprotocols, and starts the Client's read/write pumps.
+func (m *Manager) serveWS(w http.ResponseWriter, r *http.Request) {
+	m.RLock()
+	accepting := m.accepting
+	sizePolicy := m.sizePolicy
+	m.RUnlock()
+	if !accepting {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, err := websocketUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	options := DefaultClientOptions
+	options.SizePolicy = sizePolicy
+	// MaxMessageSize is SetReadLimit's hard ceiling: it kills the connection
+	// outright, whereas SizePolicy rejects an oversized event with a
+	// structured error and keeps the socket open. Raise it to cover the
+	// largest configured SizePolicy limit so that softer check actually
+	// gets to run instead of being preempted by the hard one.
+	if max := sizePolicy.max(); max > options.MaxMessageSize {
+		options.MaxMessageSize = max
+	}
+	client := NewClient(conn, m, options)
+	client.SetCodec(NegotiateCodec([]string{conn.Subprotocol()}))
+	m.addClient(client)
+
+	go client.readMessages()
+	go client.writeMessages()
+}
+
+// addClient registers client with the Manager
+func (m *Manager) addClient(client *Client) {
+	m.Lock()
+	defer m.Unlock()
+	m.clients[client] = true
+}
+
+// removeClient unregisters client and closes its connection, if it hasn't
+// already been removed.
+func (m *Manager) removeClient(client *Client) {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.clients[client]; ok {
+		client.connection.Close()
+		delete(m.clients, client)
+		m.metrics.recordDisconnect()
+	}
+}
+
+// Shutdown stops accepting new upgrades, tells every connected client to
+// close with CloseServiceRestart, and waits for their egress queues to drain
+// up to ctx's deadline before force-closing any stragglers. Callers running
+// behind a rolling deploy should call this instead of just exiting, so
+// clients see a clean 1012 rather than an abnormal closure.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.Lock()
+	m.accepting = false
+	clients := make([]*Client, 0, len(m.clients))
+	for c := range m.clients {
+		clients = append(clients, c)
+	}
+	m.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		c.close(websocket.CloseServiceRestart, "server shutting down")
+
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			// Wait for writeMessages to actually drain the egress queue and
+			// send the close frame, not just for close() to signal intent.
+			<-c.Closed()
+		}(c)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		m.Lock()
+		for c := range m.clients {
+			c.connection.Close()
+		}
+		m.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestManagerSetMaxSize verifies SetMaxSize/SetDefaultMaxSize update the
+// SizePolicy handed to clients created afterwards, without disturbing
+// concurrent readers of the policy already in use.
+func TestManagerSetMaxSize(t *testing.T) {
+	m := NewManager()
+
+	m.SetDefaultMaxSize(1 << 10)
+	m.SetMaxSize("send_message", 8<<10)
+
+	m.RLock()
+	policy := m.sizePolicy
+	m.RUnlock()
+
+	if got, want := policy.LimitFor("send_message"), int64(8<<10); got != want {
+		t.Errorf("LimitFor(send_message) = %d, want %d", got, want)
+	}
+	if got, want := policy.LimitFor("other"), int64(1<<10); got != want {
+		t.Errorf("LimitFor(other) = %d, want %d", got, want)
+	}
+
+	// Registering another type must not mutate the map backing the policy
+	// snapshot already taken above.
+	m.SetMaxSize("file_upload", 1<<20)
+	if got, want := policy.LimitFor("file_upload"), int64(1<<10); got != want {
+		t.Errorf("LimitFor(file_upload) on stale snapshot = %d, want default %d", got, want)
+	}
+}
+
+// TestServeWSHonoursSizePolicyOverHardReadLimit reproduces the bug where a
+// Manager-configured per-type SizePolicy limit was defeated by serveWS
+// leaving MaxMessageSize (SetReadLimit's hard, connection-killing ceiling)
+// at its DefaultClientOptions value of 512 bytes. A message well within the
+// configured SizePolicy limit, but over 512 bytes, must be routed rather
+// than getting the connection killed with a 1009 close.
+func TestServeWSHonoursSizePolicyOverHardReadLimit(t *testing.T) {
+	m := NewManager()
+	m.SetMaxSize("send_message", 8<<10)
+
+	routed := make(chan Event, 1)
+	m.handlers["send_message"] = func(event Event, c *Client) error {
+		routed <- event
+		return nil
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(m.serveWS))
+	defer server.Close()
+
+	wsURL := "ws" + server.URL[len("http"):]
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	payload, err := json.Marshal(map[string]string{"body": strings.Repeat("a", 2<<10)})
+	if err != nil {
+		t.Fatalf("marshal payload: %v", err)
+	}
+	if err := conn.WriteJSON(Event{Type: "send_message", Payload: payload}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	select {
+	case <-routed:
+	case <-time.After(time.Second):
+		t.Fatal("event was never routed; connection was likely killed by the hard read limit before SizePolicy got a chance to allow it")
+	}
+}
+
+// TestManagerShutdownWaitsForDrain verifies that Shutdown blocks until each
+// client's writeMessages goroutine has actually finished (signalled via
+// Client.closed), rather than returning as soon as close() has been called.
+func TestManagerShutdownWaitsForDrain(t *testing.T) {
+	m := NewManager()
+
+	c := &Client{
+		manager:     m,
+		egress:      make(chan Event, 1),
+		closeSignal: make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+	m.addClient(c)
+
+	const drainDelay = 50 * time.Millisecond
+	go func() {
+		// Stand in for writeMessages: only finish draining (and signal
+		// Closed()) a little while after close() asks us to.
+		<-c.closeSignal
+		time.Sleep(drainDelay)
+		close(c.closed)
+	}()
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown returned error: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < drainDelay {
+		t.Fatalf("Shutdown returned after %s, want at least %s (it must wait for Closed())", elapsed, drainDelay)
+	}
+}
+
+// TestManagerShutdownRespectsContextDeadline verifies that Shutdown gives up
+// and force-closes stragglers once ctx expires, instead of blocking forever
+// on a client whose writeMessages never exits.
+func TestManagerShutdownRespectsContextDeadline(t *testing.T) {
+	m := NewManager()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocketUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		// Hold the connection open until the test is done; this test only
+		// cares that Shutdown force-closes it once ctx expires.
+		<-done
+		conn.Close()
+	}))
+	defer server.Close()
+
+	wsURL := "ws" + server.URL[len("http"):]
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	c := &Client{
+		manager:     m,
+		connection:  conn,
+		egress:      make(chan Event, 1),
+		closeSignal: make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+	m.addClient(c)
+	// Never close c.closed: simulates a writeMessages goroutine that is
+	// stuck and never drains.
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err = m.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown error = %v, want context.DeadlineExceeded", err)
+	}
+}
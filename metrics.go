@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rttBuckets defines the upper bounds used to histogram measured RTTs.
+// A zero upper bound means "+Inf", i.e. the catch-all bucket.
+var rttBuckets = []struct {
+	label string
+	upper time.Duration
+}{
+	{"10ms", 10 * time.Millisecond},
+	{"50ms", 50 * time.Millisecond},
+	{"100ms", 100 * time.Millisecond},
+	{"500ms", 500 * time.Millisecond},
+	{"1s", time.Second},
+	{"+Inf", 0},
+}
+
+// MetricsSnapshot is a point-in-time read of the server's counters.
+type MetricsSnapshot struct {
+	ConnectedClients int64
+	MessagesIn       int64
+	MessagesOut      int64
+	BytesIn          int64
+	BytesOut         int64
+	// RTTHistogram buckets ping/pong round-trip times by upper bound label.
+	RTTHistogram map[string]int64
+}
+
+// metricsRegistry is the counter set a single Manager's Clients report into.
+type metricsRegistry struct {
+	connectedClients int64
+	messagesIn       int64
+	messagesOut      int64
+	bytesIn          int64
+	bytesOut         int64
+
+	mu  sync.Mutex
+	rtt map[string]int64
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{rtt: make(map[string]int64, len(rttBuckets))}
+}
+
+func (r *metricsRegistry) recordConnect()    { atomic.AddInt64(&r.connectedClients, 1) }
+func (r *metricsRegistry) recordDisconnect() { atomic.AddInt64(&r.connectedClients, -1) }
+
+func (r *metricsRegistry) recordMessageIn(n int) {
+	atomic.AddInt64(&r.messagesIn, 1)
+	atomic.AddInt64(&r.bytesIn, int64(n))
+}
+
+func (r *metricsRegistry) recordMessageOut(n int) {
+	atomic.AddInt64(&r.messagesOut, 1)
+	atomic.AddInt64(&r.bytesOut, int64(n))
+}
+
+func (r *metricsRegistry) recordRTT(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, b := range rttBuckets {
+		if b.upper == 0 || d <= b.upper {
+			r.rtt[b.label]++
+			return
+		}
+	}
+}
+
+func (r *metricsRegistry) snapshot() MetricsSnapshot {
+	r.mu.Lock()
+	hist := make(map[string]int64, len(r.rtt))
+	for k, v := range r.rtt {
+		hist[k] = v
+	}
+	r.mu.Unlock()
+
+	return MetricsSnapshot{
+		ConnectedClients: atomic.LoadInt64(&r.connectedClients),
+		MessagesIn:       atomic.LoadInt64(&r.messagesIn),
+		MessagesOut:      atomic.LoadInt64(&r.messagesOut),
+		BytesIn:          atomic.LoadInt64(&r.bytesIn),
+		BytesOut:         atomic.LoadInt64(&r.bytesOut),
+		RTTHistogram:     hist,
+	}
+}
+
+// Metrics returns a snapshot of connected clients, message/byte counters and
+// the RTT histogram for the clients this Manager owns.
+func (m *Manager) Metrics() MetricsSnapshot {
+	return m.metrics.snapshot()
+}
+
+// MetricsHandler serves Metrics() in Prometheus text exposition format.
+// Mount it at /metrics, e.g. http.HandleFunc("/metrics", manager.MetricsHandler).
+func (m *Manager) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	snap := m.Metrics()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP websockets_connected_clients Currently connected websocket clients")
+	fmt.Fprintln(w, "# TYPE websockets_connected_clients gauge")
+	fmt.Fprintf(w, "websockets_connected_clients %d\n", snap.ConnectedClients)
+
+	fmt.Fprintln(w, "# HELP websockets_messages_total Messages processed, by direction")
+	fmt.Fprintln(w, "# TYPE websockets_messages_total counter")
+	fmt.Fprintf(w, "websockets_messages_total{direction=\"in\"} %d\n", snap.MessagesIn)
+	fmt.Fprintf(w, "websockets_messages_total{direction=\"out\"} %d\n", snap.MessagesOut)
+
+	fmt.Fprintln(w, "# HELP websockets_bytes_total Bytes transferred, by direction")
+	fmt.Fprintln(w, "# TYPE websockets_bytes_total counter")
+	fmt.Fprintf(w, "websockets_bytes_total{direction=\"in\"} %d\n", snap.BytesIn)
+	fmt.Fprintf(w, "websockets_bytes_total{direction=\"out\"} %d\n", snap.BytesOut)
+
+	fmt.Fprintln(w, "# HELP websockets_ping_rtt_bucket Ping/pong round-trip time histogram")
+	fmt.Fprintln(w, "# TYPE websockets_ping_rtt_bucket counter")
+	for _, b := range rttBuckets {
+		fmt.Fprintf(w, "websockets_ping_rtt_bucket{le=%q} %d\n", b.label, snap.RTTHistogram[b.label])
+	}
+}
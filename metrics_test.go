@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+// TestManagerMetricsAreScopedPerManager verifies two Managers don't share a
+// counter set: connecting a client on one must not move the other's
+// Metrics().ConnectedClients.
+func TestManagerMetricsAreScopedPerManager(t *testing.T) {
+	m1 := NewManager()
+	m2 := NewManager()
+
+	m1.metrics.recordConnect()
+	m1.metrics.recordConnect()
+	m2.metrics.recordConnect()
+
+	if got, want := m1.Metrics().ConnectedClients, int64(2); got != want {
+		t.Errorf("m1.Metrics().ConnectedClients = %d, want %d", got, want)
+	}
+	if got, want := m2.Metrics().ConnectedClients, int64(1); got != want {
+		t.Errorf("m2.Metrics().ConnectedClients = %d, want %d", got, want)
+	}
+}
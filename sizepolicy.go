@@ -0,0 +1,45 @@
+package main
+
+// SizePolicy maps an event Type to the maximum payload size, in bytes, the
+// server accepts for that type. Types absent from Limits fall back to
+// Default. This replaces the single hard-coded read limit so a chatty type
+// like "send_message" can stay small while e.g. a file-attachment event gets
+// more room.
+type SizePolicy struct {
+	// Default is used for any event Type not present in Limits.
+	Default int64
+	// Limits overrides Default for specific event types.
+	Limits map[string]int64
+}
+
+// DefaultSizePolicy is used when ClientOptions.SizePolicy is left at its zero value.
+var DefaultSizePolicy = SizePolicy{Default: 4 << 10}
+
+// LimitFor returns the size limit, in bytes, configured for eventType.
+func (p SizePolicy) LimitFor(eventType string) int64 {
+	if p.Limits != nil {
+		if limit, ok := p.Limits[eventType]; ok {
+			return limit
+		}
+	}
+	if p.Default > 0 {
+		return p.Default
+	}
+	return DefaultSizePolicy.Default
+}
+
+// max returns the largest limit across Default and every registered type. It
+// bounds how much we read off the wire before we've decoded far enough to
+// know which per-type limit actually applies.
+func (p SizePolicy) max() int64 {
+	max := p.Default
+	for _, limit := range p.Limits {
+		if limit > max {
+			max = limit
+		}
+	}
+	if max <= 0 {
+		max = DefaultSizePolicy.Default
+	}
+	return max
+}
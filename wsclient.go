@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrWSClientClosed is returned by SendEvent once the WSClient has given up
+// reconnecting (either Backoff.MaxAttempts was exhausted or Close was called).
+var ErrWSClientClosed = errors.New("wsclient: closed")
+
+// pingControlWait bounds how long writing a single Pong control frame (in
+// response to the server's ping) is allowed to block.
+const pingControlWait = 1 * time.Second
+
+// ClientEventHandler reacts to an Event received by a WSClient. It mirrors
+// the server-side EventHandler, minus the *Client argument, since from the
+// library's perspective there is only ever one connection.
+type ClientEventHandler func(event Event) error
+
+// Backoff controls the delay between reconnect attempts.
+type Backoff struct {
+	// Min is the delay before the first retry.
+	Min time.Duration
+	// Max is the ceiling the delay backs off to.
+	Max time.Duration
+	// Jitter is the fraction (0-1) of the computed delay to randomize, so a
+	// thundering herd of clients don't all redial in lockstep.
+	Jitter float64
+	// MaxAttempts caps how many times we will redial before giving up.
+	// 0 means retry forever.
+	MaxAttempts int
+}
+
+// DefaultBackoff is used when WSClientConfig.Backoff is left at its zero value.
+var DefaultBackoff = Backoff{
+	Min:    500 * time.Millisecond,
+	Max:    30 * time.Second,
+	Jitter: 0.2,
+}
+
+// next returns the delay to wait before attempt n (0-indexed).
+func (b Backoff) next(n int) time.Duration {
+	d := b.Min << uint(n)
+	if d <= 0 || d > b.Max {
+		d = b.Max
+	}
+	if b.Jitter > 0 {
+		jitter := time.Duration(float64(d) * b.Jitter * (rand.Float64()*2 - 1))
+		d += jitter
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// WSClientConfig configures a WSClient.
+type WSClientConfig struct {
+	// URL is the server's websocket endpoint, e.g. "ws://localhost:8080/ws".
+	URL string
+	// Header is sent with every dial attempt, useful for cookies or an auth token.
+	Header http.Header
+	// Backoff controls reconnect pacing. The zero value uses DefaultBackoff.
+	Backoff Backoff
+	// OutboundBuffer bounds how many Events are queued while disconnected.
+	// Once full, SendEvent blocks until a reconnect drains the queue.
+	OutboundBuffer int
+}
+
+// WSClient is an auto-reconnecting websocket client for the server's /ws
+// endpoint. It mirrors the server-side Client, but runs the read/write pump
+// under supervision: on unexpected close or dial error it reconnects with
+// exponential backoff, re-joins any rooms the caller had subscribed to, and
+// replays Events that were queued while disconnected.
+type WSClient struct {
+	cfg WSClientConfig
+
+	mu    sync.Mutex
+	conn  *websocket.Conn
+	rooms map[string]struct{}
+
+	handlersMu sync.RWMutex
+	handlers   map[string]ClientEventHandler
+
+	outbound chan Event
+
+	closeOnce sync.Once
+	done      chan struct{}
+	err       error
+	errMu     sync.Mutex
+}
+
+// NewWSClient builds a WSClient from cfg but does not dial yet; call Connect.
+func NewWSClient(cfg WSClientConfig) *WSClient {
+	if cfg.Backoff == (Backoff{}) {
+		cfg.Backoff = DefaultBackoff
+	}
+	if cfg.OutboundBuffer <= 0 {
+		cfg.OutboundBuffer = 256
+	}
+	return &WSClient{
+		cfg:      cfg,
+		rooms:    make(map[string]struct{}),
+		handlers: make(map[string]ClientEventHandler),
+		outbound: make(chan Event, cfg.OutboundBuffer),
+		done:     make(chan struct{}),
+	}
+}
+
+// Connect dials the server once and, on success, starts the supervised
+// read/write pump that keeps the connection alive (reconnecting as needed)
+// until Close is called or backoff gives up.
+func (c *WSClient) Connect() error {
+	conn, _, err := websocket.DefaultDialer.Dial(c.cfg.URL, c.cfg.Header)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+
+	go c.supervise()
+	return nil
+}
+
+// OnEvent registers h to be called for every received Event of the given type.
+// Registering a second handler for the same type replaces the first.
+func (c *WSClient) OnEvent(eventType string, h ClientEventHandler) {
+	c.handlersMu.Lock()
+	defer c.handlersMu.Unlock()
+	c.handlers[eventType] = h
+}
+
+// SendEvent queues event for delivery. If the connection is currently down,
+// the event sits in the bounded outbound queue and is replayed once the
+// client reconnects. It returns ErrWSClientClosed if the client has given up.
+func (c *WSClient) SendEvent(event Event) error {
+	select {
+	case <-c.done:
+		return ErrWSClientClosed
+	default:
+	}
+	select {
+	case c.outbound <- event:
+		return nil
+	case <-c.done:
+		return ErrWSClientClosed
+	}
+}
+
+// JoinRoom sends a join_room Event and remembers room so it is automatically
+// re-joined after a reconnect.
+func (c *WSClient) JoinRoom(room string) error {
+	c.mu.Lock()
+	c.rooms[room] = struct{}{}
+	c.mu.Unlock()
+
+	payload, err := json.Marshal(struct {
+		Room string `json:"room"`
+	}{Room: room})
+	if err != nil {
+		return err
+	}
+	return c.SendEvent(Event{Type: "join_room", Payload: payload})
+}
+
+// Done returns a channel that is closed once the client has permanently
+// stopped (backoff exhausted, or Close was called). Check Err afterwards.
+func (c *WSClient) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the terminal error that caused the client to stop, if any.
+// It is only meaningful after Done has been closed.
+func (c *WSClient) Err() error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	return c.err
+}
+
+// Close stops the client and closes the underlying connection.
+func (c *WSClient) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.mu.Lock()
+		conn := c.conn
+		c.mu.Unlock()
+		if conn != nil {
+			conn.Close()
+		}
+	})
+	return nil
+}
+
+// supervise owns the lifetime of the connection: it runs one read/write pump
+// per connection, and on failure dials again with backoff until MaxAttempts
+// is exhausted or Close is called.
+func (c *WSClient) supervise() {
+	attempt := 0
+	for {
+		pumpErr := c.runPumps()
+
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+
+		if pumpErr != nil {
+			log.Println("wsclient: connection lost:", pumpErr)
+		}
+
+		if c.cfg.Backoff.MaxAttempts > 0 && attempt >= c.cfg.Backoff.MaxAttempts {
+			c.fail(pumpErr)
+			return
+		}
+
+		delay := c.cfg.Backoff.next(attempt)
+		attempt++
+
+		select {
+		case <-time.After(delay):
+		case <-c.done:
+			return
+		}
+
+		conn, _, err := websocket.DefaultDialer.Dial(c.cfg.URL, c.cfg.Header)
+		if err != nil {
+			log.Println("wsclient: redial failed:", err)
+			continue
+		}
+
+		c.mu.Lock()
+		c.conn = conn
+		c.mu.Unlock()
+
+		c.resubscribe()
+		attempt = 0
+	}
+}
+
+// resubscribe re-joins every room the caller had joined before the
+// disconnect, so the server sees an identical subscription set.
+func (c *WSClient) resubscribe() {
+	c.mu.Lock()
+	rooms := make([]string, 0, len(c.rooms))
+	for room := range c.rooms {
+		rooms = append(rooms, room)
+	}
+	c.mu.Unlock()
+
+	for _, room := range rooms {
+		payload, err := json.Marshal(struct {
+			Room string `json:"room"`
+		}{Room: room})
+		if err != nil {
+			continue
+		}
+		select {
+		case c.outbound <- (Event{Type: "join_room", Payload: payload}):
+		default:
+			log.Println("wsclient: outbound queue full, dropping resubscribe for", room)
+		}
+	}
+}
+
+// runPumps drives a single connection's read and write loops until either
+// one returns, then tears the connection down.
+func (c *WSClient) runPumps() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return errors.New("wsclient: no connection")
+	}
+
+	errCh := make(chan error, 2)
+	stop := make(chan struct{})
+
+	go func() {
+		errCh <- c.readPump(conn, stop)
+	}()
+	go func() {
+		errCh <- c.writePump(conn, stop)
+	}()
+
+	err := <-errCh
+	close(stop)
+	// Close conn as soon as either pump exits, rather than deferring to
+	// function return: if writePump hits a write error while readPump is
+	// blocked in ReadMessage on a connection that has gone silent, closing
+	// stop alone can't unblock that read — only closing conn does, and
+	// without it supervise would never redial.
+	conn.Close()
+	<-errCh
+	return err
+}
+
+func (c *WSClient) readPump(conn *websocket.Conn, stop <-chan struct{}) error {
+	if err := conn.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
+		return err
+	}
+	conn.SetPingHandler(func(appData string) error {
+		if err := conn.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
+			return err
+		}
+		return conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(pingControlWait))
+	})
+
+	for {
+		_, payload, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var event Event
+		if err := json.Unmarshal(payload, &event); err != nil {
+			log.Println("wsclient: error unmarshalling event:", err)
+			continue
+		}
+		c.handlersMu.RLock()
+		h, ok := c.handlers[event.Type]
+		c.handlersMu.RUnlock()
+		if !ok {
+			continue
+		}
+		if err := h(event); err != nil {
+			log.Println("wsclient: handler error:", err)
+		}
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+	}
+}
+
+func (c *WSClient) writePump(conn *websocket.Conn, stop <-chan struct{}) error {
+	for {
+		select {
+		case event := <-c.outbound:
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Println("wsclient: error marshalling event:", err)
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				// Put the event back so it is retried after the next reconnect.
+				select {
+				case c.outbound <- event:
+				default:
+				}
+				return err
+			}
+		case <-stop:
+			return nil
+		case <-c.done:
+			return nil
+		}
+	}
+}
+
+// fail marks the client as permanently stopped with err.
+func (c *WSClient) fail(err error) {
+	c.errMu.Lock()
+	c.err = err
+	c.errMu.Unlock()
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestBackoffNext checks that delays grow, stay within [0, Max], and respect
+// the configured ceiling once attempts exceed it.
+func TestBackoffNext(t *testing.T) {
+	b := Backoff{Min: 100 * time.Millisecond, Max: time.Second, Jitter: 0}
+
+	prev := time.Duration(0)
+	for n := 0; n < 10; n++ {
+		d := b.next(n)
+		if d < 0 || d > b.Max {
+			t.Fatalf("next(%d) = %s, want within [0, %s]", n, d, b.Max)
+		}
+		if n > 0 && d < prev && d != b.Max {
+			t.Fatalf("next(%d) = %s, want >= next(%d) = %s (until capped at Max)", n, d, n-1, prev)
+		}
+		prev = d
+	}
+
+	if d := b.next(4); d != b.Max {
+		t.Fatalf("next(4) = %s, want capped at Max = %s", d, b.Max)
+	}
+}
+
+// TestRunPumpsUnblocksOnStalledConnection reproduces the one-directional
+// network failure runPumps used to deadlock on: the peer stops reading and
+// writing entirely (no close frame, no error), so readPump's ReadMessage
+// never returns on its own. Without a read deadline, runPumps would block
+// forever. With one, it must return once the deadline elapses.
+func TestRunPumpsUnblocksOnStalledConnection(t *testing.T) {
+	origPongWait := pongWait
+	pongWait = 100 * time.Millisecond
+	defer func() { pongWait = origPongWait }()
+
+	ready := make(chan struct{})
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocketUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		close(ready)
+		// Simulate a silently stalled peer: never read or write again, and
+		// don't close the connection either.
+		<-done
+		conn.Close()
+	}))
+	defer server.Close()
+	defer close(done)
+
+	wsURL := "ws" + server.URL[len("http"):]
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	<-ready
+
+	c := NewWSClient(WSClientConfig{URL: wsURL})
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- c.runPumps() }()
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Fatal("runPumps returned nil error, want a read-deadline-exceeded error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("runPumps did not return after the peer went silent; read deadline was not enforced")
+	}
+}